@@ -0,0 +1,243 @@
+// Package ani parses the RIFF/ACON container used by Windows .ani animated cursor files.
+package ani
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/ur65/go-ico"
+)
+
+// jiffy is the unit ani headers and rate chunks express durations in: 1/60 of a second.
+const jiffy = time.Second / 60
+
+const flagIcon = 1 << 0
+
+// Animation is a decoded .ani file.
+type Animation struct {
+	// Frames holds each animation frame's decoded cursor images, in storage order. A frame
+	// is the payload of one "icon" chunk, which may itself hold more than one ico.Cursor
+	// when it is a multi-resolution .cur; Sequence indexes into Frames, not into some
+	// flattened per-image list.
+	Frames [][]ico.Cursor
+	// Rate holds each playback step's display duration.
+	Rate []time.Duration
+	// Sequence maps each playback step to an index into Frames. Absent a "seq " chunk,
+	// this is just 0..len(Frames)-1.
+	Sequence []int
+}
+
+type aniHeader struct {
+	Size     uint32
+	Frames   uint32
+	Steps    uint32
+	Width    uint32
+	Height   uint32
+	BitCount uint32
+	Planes   uint32
+	JIFRate  uint32
+	Flags    uint32
+}
+
+// Decode reads r as a RIFF/ACON .ani stream and returns its parsed animation.
+func Decode(r io.Reader) (*Animation, error) {
+	size, err := readRIFFHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		anih   *aniHeader
+		frames [][]byte
+		rate   []uint32
+		seq    []uint32
+	)
+
+	lr := io.LimitReader(r, int64(size)-4)
+	for {
+		id, data, err := readChunk(lr)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch id {
+		case "anih":
+			anih, err = parseAniHeader(data)
+			if err != nil {
+				return nil, err
+			}
+		case "LIST":
+			if len(data) < 4 || string(data[:4]) != "fram" {
+				continue
+			}
+			if frames, err = readFrames(data[4:]); err != nil {
+				return nil, err
+			}
+		case "rate":
+			if rate, err = readUint32s(data); err != nil {
+				return nil, err
+			}
+		case "seq ":
+			if seq, err = readUint32s(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if anih == nil {
+		return nil, fmt.Errorf("ani: missing anih chunk")
+	}
+	if anih.Flags&flagIcon == 0 {
+		return nil, fmt.Errorf("ani: frames stored as raw DIB data (ANI_NONE_FLAG) are not supported")
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("ani: missing LIST fram chunk")
+	}
+
+	a := &Animation{Frames: make([][]ico.Cursor, len(frames))}
+	for i, fd := range frames {
+		cursors, err := decodeFrame(fd)
+		if err != nil {
+			return nil, err
+		}
+		a.Frames[i] = cursors
+	}
+
+	steps := int(anih.Steps)
+	if steps == 0 {
+		steps = len(a.Frames)
+	}
+
+	a.Rate = make([]time.Duration, steps)
+	for i := range a.Rate {
+		jif := anih.JIFRate
+		if i < len(rate) {
+			jif = rate[i]
+		}
+		a.Rate[i] = time.Duration(jif) * jiffy
+	}
+
+	a.Sequence = make([]int, steps)
+	for i := range a.Sequence {
+		if i < len(seq) {
+			a.Sequence[i] = int(seq[i])
+		} else {
+			a.Sequence[i] = i
+		}
+		if a.Sequence[i] < 0 || a.Sequence[i] >= len(a.Frames) {
+			return nil, fmt.Errorf("ani: sequence step %d indexes frame %d, out of range for %d frames", i, a.Sequence[i], len(a.Frames))
+		}
+	}
+
+	return a, nil
+}
+
+// decodeFrame decodes one "icon" chunk's payload, a full CUR stream, delegating to the
+// existing ICO/CUR pipeline.
+func decodeFrame(data []byte) ([]ico.Cursor, error) {
+	return ico.DecodeCursor(bytes.NewReader(data))
+}
+
+func readRIFFHeader(r io.Reader) (uint32, error) {
+	if err := expectFourCC(r, "RIFF"); err != nil {
+		return 0, err
+	}
+
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return 0, err
+	}
+
+	if err := expectFourCC(r, "ACON"); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+func expectFourCC(r io.Reader, want string) error {
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(r, got); err != nil {
+		return err
+	}
+	if string(got) != want {
+		return fmt.Errorf("ani: expected %q chunk (got: %q)", want, got)
+	}
+	return nil
+}
+
+// readChunk reads one "RIFF chunk": a 4-byte ID, a little-endian uint32 size and that many
+// bytes of data, discarding the trailing pad byte RIFF chunks carry when size is odd.
+func readChunk(r io.Reader) (id string, data []byte, err error) {
+	idb := make([]byte, 4)
+	if _, err := io.ReadFull(r, idb); err != nil {
+		return "", nil, err
+	}
+
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return "", nil, err
+	}
+
+	data = make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", nil, err
+	}
+
+	if size%2 == 1 {
+		if _, err := io.CopyN(ioutil.Discard, r, 1); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return string(idb), data, nil
+}
+
+// readFrames walks a "LIST fram" chunk's body and returns the payload of each nested
+// "icon" chunk.
+func readFrames(data []byte) ([][]byte, error) {
+	var frames [][]byte
+
+	r := bytes.NewReader(data)
+	for {
+		id, fd, err := readChunk(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if id == "icon" {
+			frames = append(frames, fd)
+		}
+	}
+
+	return frames, nil
+}
+
+func readUint32s(data []byte) ([]uint32, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("ani: chunk size is not a multiple of 4 (got: %d)", len(data))
+	}
+
+	vs := make([]uint32, len(data)/4)
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &vs); err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+func parseAniHeader(data []byte) (*aniHeader, error) {
+	h := &aniHeader{}
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
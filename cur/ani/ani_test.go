@@ -0,0 +1,117 @@
+package ani
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/ur65/go-ico"
+)
+
+// writeChunk encodes a RIFF chunk: a 4-byte ID, a little-endian uint32 size, the data, and
+// a trailing pad byte when the data length is odd.
+func writeChunk(buf *bytes.Buffer, id string, data []byte) {
+	buf.WriteString(id)
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+func curFile(t *testing.T, n int) []byte {
+	t.Helper()
+
+	imgs := make([]image.Image, n)
+	hotspots := make([]ico.Hotspot, n)
+	for i := range imgs {
+		imgs[i] = image.NewRGBA(image.Rect(0, 0, 2+i, 2+i))
+	}
+
+	var buf bytes.Buffer
+	if err := ico.EncodeCUR(&buf, imgs, hotspots, nil); err != nil {
+		t.Fatalf("EncodeCUR: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// buildANI assembles a minimal RIFF/ACON stream with one icon chunk per entry in
+// frameCounts (each holding that many cursor images) and, if seq is non-nil, a "seq "
+// chunk carrying it.
+func buildANI(t *testing.T, anih aniHeader, frameCounts []int, seq []uint32) []byte {
+	t.Helper()
+
+	var anihData bytes.Buffer
+	binary.Write(&anihData, binary.LittleEndian, anih)
+
+	var fram bytes.Buffer
+	fram.WriteString("fram")
+	for _, n := range frameCounts {
+		writeChunk(&fram, "icon", curFile(t, n))
+	}
+
+	var body bytes.Buffer
+	writeChunk(&body, "anih", anihData.Bytes())
+	writeChunk(&body, "LIST", fram.Bytes())
+	if seq != nil {
+		var seqData bytes.Buffer
+		binary.Write(&seqData, binary.LittleEndian, seq)
+		writeChunk(&body, "seq ", seqData.Bytes())
+	}
+
+	var riff bytes.Buffer
+	riff.WriteString("RIFF")
+	binary.Write(&riff, binary.LittleEndian, uint32(4+body.Len()))
+	riff.WriteString("ACON")
+	riff.Write(body.Bytes())
+
+	return riff.Bytes()
+}
+
+func TestDecodeGroupsFramesPerChunk(t *testing.T) {
+	anih := aniHeader{Size: 36, Frames: 2, Steps: 0, JIFRate: 6, Flags: flagIcon}
+	data := buildANI(t, anih, []int{2, 1}, nil) // multi-resolution frame, then a single-image frame
+
+	a, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if len(a.Frames) != 2 {
+		t.Fatalf("len(Frames) = %d, want 2 (one per icon chunk)", len(a.Frames))
+	}
+	if len(a.Frames[0]) != 2 {
+		t.Errorf("len(Frames[0]) = %d, want 2 (multi-resolution frame)", len(a.Frames[0]))
+	}
+	if len(a.Frames[1]) != 1 {
+		t.Errorf("len(Frames[1]) = %d, want 1", len(a.Frames[1]))
+	}
+
+	if len(a.Sequence) != 2 {
+		t.Fatalf("len(Sequence) = %d, want 2 (no seq chunk, steps defaults to len(Frames))", len(a.Sequence))
+	}
+	for i, s := range a.Sequence {
+		if s != i {
+			t.Errorf("Sequence[%d] = %d, want %d", i, s, i)
+		}
+	}
+}
+
+func TestDecodeRejectsStepsExceedingFrames(t *testing.T) {
+	anih := aniHeader{Size: 36, Frames: 2, Steps: 5, JIFRate: 6, Flags: flagIcon}
+	data := buildANI(t, anih, []int{1, 1}, nil)
+
+	if _, err := Decode(bytes.NewReader(data)); err == nil {
+		t.Fatalf("Decode succeeded with Steps (5) exceeding Frames (2), want an error")
+	}
+}
+
+func TestDecodeRejectsOutOfRangeSeq(t *testing.T) {
+	anih := aniHeader{Size: 36, Frames: 2, Steps: 2, JIFRate: 6, Flags: flagIcon}
+	data := buildANI(t, anih, []int{1, 1}, []uint32{0, 7})
+
+	if _, err := Decode(bytes.NewReader(data)); err == nil {
+		t.Fatalf("Decode succeeded with a seq entry (7) out of range for 2 frames, want an error")
+	}
+}
@@ -1,6 +1,7 @@
 package ico
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
@@ -14,6 +15,10 @@ import (
 	"github.com/ur65/go-ico/internal/bmp"
 )
 
+func init() {
+	image.RegisterFormat("ico", "\x00\x00\x01\x00", DecodeFirst, DecodeConfig)
+}
+
 const (
 	headerSize    = 6
 	directorySize = 16
@@ -34,8 +39,8 @@ func readHeader(r io.Reader) (header, error) {
 	h := header{}
 	binary.Read(r, binary.LittleEndian, &h)
 
-	if h.ImageType != 1 {
-		return h, fmt.Errorf("ico: image type should be 1 (got: %d)", h.ImageType)
+	if h.ImageType != 1 && h.ImageType != 2 {
+		return h, fmt.Errorf("ico: image type should be 1 or 2 (got: %d)", h.ImageType)
 	}
 
 	if h.Count <= 0 {
@@ -125,6 +130,10 @@ func newBMPReader(dir directory, data []byte) (xor, and *bmpReader, err error) {
 	binary.Write(bb, binary.LittleEndian, uint32(ih.ColorUsed))
 	binary.Write(bb, binary.LittleEndian, uint32(ih.ColorImportant))
 
+	// BITMAPV4HEADER/BITMAPV5HEADER extension, so bmp.Decode on the reconstructed stream
+	// sees the same masks/color-space info ReadInfoHeader parsed out of the ICO entry.
+	writeDIBExtension(bb, ih)
+
 	// COLOR TABLE + IMAGEDATA
 	bb.Write(d[:xorSize])
 
@@ -171,6 +180,39 @@ func newBMPReader(dir directory, data []byte) (xor, and *bmpReader, err error) {
 	return xor, and, nil
 }
 
+// writeDIBExtension writes the BITMAPV4HEADER/BITMAPV5HEADER fields that follow the common
+// 40-byte BITMAPINFOHEADER, so a BMP reconstructed by newBMPReader round-trips the same
+// masks and color-space info bmp.ReadInfoHeader parsed out of the source ICO entry. It is a
+// no-op when ih.Size is the plain 40-byte BITMAPINFOHEADER.
+func writeDIBExtension(bb *bytes.Buffer, ih bmp.InfoHeader) {
+	if ih.Size < 108 {
+		return
+	}
+
+	binary.Write(bb, binary.LittleEndian, ih.RedMask)
+	binary.Write(bb, binary.LittleEndian, ih.GreenMask)
+	binary.Write(bb, binary.LittleEndian, ih.BlueMask)
+	binary.Write(bb, binary.LittleEndian, ih.AlphaMask)
+	binary.Write(bb, binary.LittleEndian, ih.CSType)
+	binary.Write(bb, binary.LittleEndian, ih.Endpoints)
+	binary.Write(bb, binary.LittleEndian, ih.GammaRed)
+	binary.Write(bb, binary.LittleEndian, ih.GammaGreen)
+	binary.Write(bb, binary.LittleEndian, ih.GammaBlue)
+
+	if ih.Size < 124 {
+		return
+	}
+
+	// An embedded ICC profile normally lives past the pixel array, but newBMPReader splits
+	// that array into separate XOR/AND streams; the profile bytes don't survive the split
+	// and belong to neither. Report no profile rather than a ProfileData/ProfileSize that
+	// bmp.Decode can't actually satisfy from the XOR stream alone.
+	binary.Write(bb, binary.LittleEndian, ih.Intent)
+	binary.Write(bb, binary.LittleEndian, uint32(0))
+	binary.Write(bb, binary.LittleEndian, uint32(0))
+	binary.Write(bb, binary.LittleEndian, ih.Reserved)
+}
+
 func (r *bmpReader) Read(p []byte) (n int, err error) {
 	if r.p >= int64(len(r.s)) {
 		return 0, io.EOF
@@ -181,8 +223,165 @@ func (r *bmpReader) Read(p []byte) (n int, err error) {
 
 }
 
-// Decode decodes the given io.Reader and returns all images contained in the data.
-func Decode(r io.Reader) ([]image.Image, error) {
+// ImageFormat selects the on-disk encoding used for a single icon or cursor image.
+type ImageFormat int
+
+const (
+	// FormatBMP embeds the image as the ICO-specific DIB payload read by newBMPReader.
+	FormatBMP ImageFormat = iota
+	// FormatPNG embeds the image as a plain PNG payload, as Windows Vista and later expect
+	// for large icons.
+	FormatPNG
+)
+
+// Hotspot is the CUR-specific click point for a single cursor image, in pixels measured
+// from the top-left corner.
+type Hotspot struct {
+	X, Y uint16
+}
+
+// EncodeOptions controls how Encode and EncodeCUR serialize each image.
+type EncodeOptions struct {
+	// Format picks the on-disk encoding for the i-th image. If nil, images whose width or
+	// height is 256px or larger are written as PNG and the rest as BMP.
+	Format func(i int, img image.Image) ImageFormat
+
+	// BitCount picks the BMP bit depth (32, 24, 8, 4 or 1) for the i-th image. It is
+	// ignored for images written as PNG. If nil, 32 is used. 8/4/1-bit images must be an
+	// *image.Paletted whose palette fits the bit depth.
+	BitCount func(i int, img image.Image) int
+
+	// AutoMask derives the BMP AND mask from each image's alpha channel (pixels with
+	// alpha below 0x80 are masked out) instead of writing a fully opaque mask.
+	AutoMask bool
+}
+
+func defaultFormat(_ int, img image.Image) ImageFormat {
+	b := img.Bounds()
+	if b.Dx() >= 256 || b.Dy() >= 256 {
+		return FormatPNG
+	}
+	return FormatBMP
+}
+
+func defaultBitCount(_ int, _ image.Image) int {
+	return 32
+}
+
+// Encode writes imgs to w as a .ico file.
+func Encode(w io.Writer, imgs []image.Image, opts *EncodeOptions) error {
+	return encode(w, 1, imgs, nil, opts)
+}
+
+// EncodeCUR writes imgs to w as a .cur file. hotspots must be the same length as imgs and
+// gives each image's hotspot coordinate.
+func EncodeCUR(w io.Writer, imgs []image.Image, hotspots []Hotspot, opts *EncodeOptions) error {
+	if len(hotspots) != len(imgs) {
+		return fmt.Errorf("ico: len(hotspots) must equal len(imgs) (got: %d, %d)", len(hotspots), len(imgs))
+	}
+	return encode(w, 2, imgs, hotspots, opts)
+}
+
+func encode(w io.Writer, imageType int16, imgs []image.Image, hotspots []Hotspot, opts *EncodeOptions) error {
+	if len(imgs) == 0 {
+		return fmt.Errorf("ico: no images to encode")
+	}
+
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	format := opts.Format
+	if format == nil {
+		format = defaultFormat
+	}
+	bitCount := opts.BitCount
+	if bitCount == nil {
+		bitCount = defaultBitCount
+	}
+
+	ds := make([]directory, len(imgs))
+	payloads := make([][]byte, len(imgs))
+	offset := int32(headerSize + directorySize*len(imgs))
+
+	for i, img := range imgs {
+		b := img.Bounds()
+
+		if b.Dx() > 256 || b.Dy() > 256 {
+			return fmt.Errorf("ico: image %d is %dx%d, exceeds the maximum ICO/CUR size of 256x256", i, b.Dx(), b.Dy())
+		}
+
+		var buf bytes.Buffer
+		d := directory{
+			// 256 wraps to the 0 sentinel below, which readers treat as 256.
+			Width:  uint8(b.Dx()),
+			Height: uint8(b.Dy()),
+		}
+
+		if hotspots != nil {
+			d.Planes = int16(hotspots[i].X)
+			d.BitCount = int16(hotspots[i].Y)
+		} else {
+			d.Planes = 1
+		}
+
+		switch format(i, img) {
+		case FormatPNG:
+			if err := png.Encode(&buf, img); err != nil {
+				return err
+			}
+			if hotspots == nil {
+				d.BitCount = 32
+			}
+		default:
+			bc := bitCount(i, img)
+			if err := bmp.Encode(&buf, img, bmp.EncodeOptions{BitCount: bc, AutoMask: opts.AutoMask}); err != nil {
+				return err
+			}
+			if hotspots == nil {
+				d.BitCount = int16(bc)
+			}
+			if bc <= 8 {
+				d.ColorCount = byte(1 << uint(bc))
+			}
+		}
+
+		payloads[i] = buf.Bytes()
+		d.BytesInRes = int32(len(payloads[i]))
+		d.ImageOffset = offset
+		offset += d.BytesInRes
+
+		ds[i] = d
+	}
+
+	h := header{ImageType: imageType, Count: int16(len(imgs))}
+	if err := binary.Write(w, binary.LittleEndian, h); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, ds); err != nil {
+		return err
+	}
+	for _, p := range payloads {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// iterator streams over the image entries of an ICO/CUR directory without buffering the
+// whole file up front: each call to next() discards the reader forward to the next
+// entry's offset and hands back a reader bounded to that entry's payload.
+type iterator struct {
+	r   io.Reader
+	pos int32
+	ds  []directory
+	idx int
+	cur *io.LimitedReader
+	typ int16
+}
+
+func newIterator(r io.Reader) (*iterator, error) {
 	h, err := readHeader(r)
 	if err != nil {
 		return nil, err
@@ -193,52 +392,360 @@ func Decode(r io.Reader) ([]image.Image, error) {
 		return nil, err
 	}
 
-	buf, err := ioutil.ReadAll(r)
+	return &iterator{r: r, pos: int32(headerSize + directorySize*len(ds)), ds: ds, typ: h.ImageType}, nil
+}
+
+// next advances to the next entry, returning its directory entry and a reader bounded to
+// its payload. The returned reader need not be fully consumed before the next call.
+func (it *iterator) next() (directory, io.Reader, error) {
+	if it.cur != nil {
+		if _, err := io.Copy(ioutil.Discard, it.cur); err != nil {
+			return directory{}, nil, err
+		}
+		it.cur = nil
+	}
+
+	if it.idx >= len(it.ds) {
+		return directory{}, nil, io.EOF
+	}
+	d := it.ds[it.idx]
+	it.idx++
+
+	if skip := d.ImageOffset - it.pos; skip < 0 {
+		return directory{}, nil, fmt.Errorf("ico: directory entries must be in ascending offset order (got offset %d after %d)", d.ImageOffset, it.pos)
+	} else if skip > 0 {
+		if _, err := io.CopyN(ioutil.Discard, it.r, int64(skip)); err != nil {
+			return directory{}, nil, err
+		}
+		it.pos += skip
+	}
+
+	it.pos += d.BytesInRes
+	it.cur = &io.LimitedReader{R: it.r, N: int64(d.BytesInRes)}
+
+	return d, it.cur, nil
+}
+
+// Entry describes one image's directory metadata, used to pick an image with
+// DecodeSelect.
+type Entry struct {
+	Width, Height int
+	BitCount      int
+}
+
+func toEntry(d directory) Entry {
+	w, h := int(d.Width), int(d.Height)
+	// when width/height is 0, it is treated as 256 instead.
+	if w == 0 {
+		w = 256
+	}
+	if h == 0 {
+		h = 256
+	}
+	return Entry{Width: w, Height: h, BitCount: int(d.BitCount)}
+}
+
+func largestEntry(entries []Entry) int {
+	best, bestArea := 0, -1
+	for i, e := range entries {
+		if area := e.Width * e.Height; area > bestArea {
+			best, bestArea = i, area
+		}
+	}
+	return best
+}
+
+// Decode decodes the given io.Reader and returns all images contained in the data.
+func Decode(r io.Reader) ([]image.Image, error) {
+	it, err := newIterator(r)
 	if err != nil {
 		return nil, err
 	}
+	if it.typ != 1 {
+		return nil, fmt.Errorf("ico: not an icon file (image type %d)", it.typ)
+	}
 
-	imgs := make([]image.Image, len(ds))
-	for i, v := range ds {
-		offset := v.ImageOffset - int32(headerSize+directorySize*len(ds))
-		size := v.BytesInRes
-		data := buf[offset : offset+size]
+	imgs := make([]image.Image, 0, len(it.ds))
+	for {
+		d, er, err := it.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
 
-		// PNG
-		if string(data[1:4]) == "PNG" {
-			img, err := png.Decode(bytes.NewReader(data))
-			if err != nil {
-				return nil, err
-			}
-			imgs[i] = img
-			continue
+		data, err := ioutil.ReadAll(er)
+		if err != nil {
+			return nil, err
 		}
 
-		// BMP
-		xor, and, err := newBMPReader(v, data)
+		img, err := decodeEntry(d, data)
 		if err != nil {
 			return nil, err
 		}
+		imgs = append(imgs, img)
+	}
+
+	return imgs, nil
+}
+
+// ImageWithProfile wraps a decoded icon/cursor image together with the color-space Profile
+// embedded in its BMP payload's BITMAPV4HEADER/BITMAPV5HEADER. decodeEntry returns this
+// instead of a plain image.Image when the source DIB header carries one, so callers that
+// care can type-assert for it.
+type ImageWithProfile struct {
+	image.Image
+	Profile bmp.Profile
+}
+
+// hasAlpha reports whether any pixel in img carries a non-zero alpha byte. Like Windows
+// itself, this can't distinguish "the encoder never wrote alpha" from "the image is
+// legitimately fully transparent"; an all-zero-alpha XOR bitmap is read as the former.
+func hasAlpha(img *image.NRGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// forceOpaque sets every pixel's alpha to 0xff in place. img is always a bmp.Decode result
+// decodeEntry doesn't otherwise retain, so it's safe to mutate rather than copy.
+func forceOpaque(img *image.NRGBA) *image.NRGBA {
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
 
-		xorImg, err := bmp.Decode(xor)
+// decodeEntry decodes a single directory entry's raw payload (either an embedded PNG or
+// the ICO-specific BMP layout read by newBMPReader).
+func decodeEntry(d directory, data []byte) (image.Image, error) {
+	// PNG
+	if len(data) >= 4 && string(data[1:4]) == "PNG" {
+		return png.Decode(bytes.NewReader(data))
+	}
+
+	// BMP
+	xor, and, err := newBMPReader(d, data)
+	if err != nil {
+		return nil, err
+	}
+
+	xorRaw, err := bmp.Decode(xor)
+	if err != nil {
+		return nil, err
+	}
+
+	xorImg := xorRaw
+	var profile *bmp.Profile
+	if wp, ok := xorRaw.(bmp.ImageWithProfile); ok {
+		xorImg = wp.Image
+		profile = &wp.Profile
+	}
+
+	// A 32bpp (or BITFIELDS-with-alpha) XOR bitmap decodes to *image.NRGBA with its own real
+	// per-pixel alpha; treat that as authoritative instead of re-compositing it against the
+	// binary AND mask, which would needlessly round the color through an extra
+	// premultiply/quantize/unpremultiply step and only matters for the shallower depths that
+	// have no alpha of their own. Some older 32bpp icons leave every alpha byte 0, using the
+	// 4th byte as unused padding and relying solely on the AND mask for transparency, same as
+	// Windows itself does; fall through to the AND mask compositing below in that case instead
+	// of returning an image that's entirely transparent.
+	if nrgba, ok := xorImg.(*image.NRGBA); ok {
+		if hasAlpha(nrgba) {
+			if profile != nil {
+				return ImageWithProfile{Image: nrgba, Profile: *profile}, nil
+			}
+			return nrgba, nil
+		}
+		// Force every pixel opaque so compositing against the AND mask below reflects the XOR
+		// bitmap's real color instead of the all-zero alpha it was decoded with.
+		xorImg = forceOpaque(nrgba)
+	}
+
+	// AND Bitmap has no transparent
+	andImg, err := bmp.Decode(and)
+	if err != nil {
+		return nil, err
+	}
+
+	// bmp.Decode always returns image.Paletted from 1 bpp BMP Image
+	andImg.(*image.Paletted).Palette[1] = color.RGBA{0, 0, 0, 0}
+
+	img := image.NewRGBA(xorImg.Bounds())
+	draw.DrawMask(img, img.Bounds(), xorImg, image.Point{0, 0}, andImg, image.Point{0, 0}, draw.Src)
+
+	if profile != nil {
+		return ImageWithProfile{Image: img, Profile: *profile}, nil
+	}
+
+	return img, nil
+}
+
+// DecodeSelect decodes and returns a single image from r, the entry chosen by selector
+// out of the directory's entries.
+func DecodeSelect(r io.Reader, selector func(entries []Entry) int) (image.Image, error) {
+	it, err := newIterator(r)
+	if err != nil {
+		return nil, err
+	}
+	if it.typ != 1 {
+		return nil, fmt.Errorf("ico: not an icon file (image type %d)", it.typ)
+	}
+	if len(it.ds) == 0 {
+		return nil, fmt.Errorf("ico: no images in directory")
+	}
+
+	entries := make([]Entry, len(it.ds))
+	for i, d := range it.ds {
+		entries[i] = toEntry(d)
+	}
+
+	idx := selector(entries)
+	if idx < 0 || idx >= len(it.ds) {
+		return nil, fmt.Errorf("ico: selector returned out-of-range index %d", idx)
+	}
+
+	var d directory
+	var er io.Reader
+	for i := 0; i <= idx; i++ {
+		d, er, err = it.next()
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	data, err := ioutil.ReadAll(er)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeEntry(d, data)
+}
+
+// DecodeFirst decodes and returns a single image from r — the largest entry in the
+// directory — so ico satisfies the decode signature required by image.RegisterFormat.
+func DecodeFirst(r io.Reader) (image.Image, error) {
+	return DecodeSelect(r, largestEntry)
+}
+
+// DecodeConfig reads just the ICONDIR, ICONDIRENTRY table and the DIB/PNG header of the
+// largest entry, returning its dimensions and color model without decoding any pixels.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	it, err := newIterator(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if it.typ != 1 {
+		return image.Config{}, fmt.Errorf("ico: not an icon file (image type %d)", it.typ)
+	}
+	if len(it.ds) == 0 {
+		return image.Config{}, fmt.Errorf("ico: no images in directory")
+	}
+
+	entries := make([]Entry, len(it.ds))
+	for i, d := range it.ds {
+		entries[i] = toEntry(d)
+	}
+	idx := largestEntry(entries)
+
+	var er io.Reader
+	for i := 0; i <= idx; i++ {
+		_, er, err = it.next()
+		if err != nil {
+			return image.Config{}, err
+		}
+	}
+
+	return decodeEntryConfig(er)
+}
 
-		// AND Bitmap has no transparent
-		andImg, err := bmp.Decode(and)
+// decodeEntryConfig peeks a directory entry's PNG or DIB header to determine its
+// dimensions and color model without reading any pixel data.
+func decodeEntryConfig(r io.Reader) (image.Config, error) {
+	br := bufio.NewReader(r)
+
+	sig, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return image.Config{}, err
+	}
+	if len(sig) >= 4 && string(sig[1:4]) == "PNG" {
+		return png.DecodeConfig(br)
+	}
+
+	ih, err := bmp.ReadInfoHeader(br)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	var model color.Model = color.RGBAModel
+	if ih.BitCount <= 8 {
+		n := int(ih.ColorUsed)
+		if n == 0 {
+			n = 1 << ih.BitCount
+		}
+		pal, err := bmp.ReadColorTable(br, n)
+		if err != nil {
+			return image.Config{}, err
+		}
+		model = pal
+	}
+
+	// ICO stores the combined XOR+AND height; the visible height is half of it.
+	height := ih.Height
+	if height < 0 {
+		height = -height
+	}
+	height /= 2
+
+	return image.Config{ColorModel: model, Width: int(ih.Width), Height: int(height)}, nil
+}
+
+// Cursor bundles a decoded .cur image with its hotspot coordinate, the pixel within the
+// image that the mouse position refers to.
+type Cursor struct {
+	Image              image.Image
+	HotspotX, HotspotY int
+}
+
+// DecodeCursor decodes the given io.Reader as a .cur file and returns all cursor images
+// it contains, along with their hotspot coordinates.
+func DecodeCursor(r io.Reader) ([]Cursor, error) {
+	it, err := newIterator(r)
+	if err != nil {
+		return nil, err
+	}
+	if it.typ != 2 {
+		return nil, fmt.Errorf("ico: not a cursor file (image type %d)", it.typ)
+	}
+
+	cursors := make([]Cursor, 0, len(it.ds))
+	for {
+		d, er, err := it.next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return nil, err
 		}
 
-		// bmp.Decode always returns image.Paletted from 1 bpp BMP Image
-		andImg.(*image.Paletted).Palette[1] = color.RGBA{0, 0, 0, 0}
+		data, err := ioutil.ReadAll(er)
+		if err != nil {
+			return nil, err
+		}
 
-		img := image.NewRGBA(xorImg.Bounds())
-		draw.DrawMask(img, img.Bounds(), xorImg, image.Point{0, 0}, andImg, image.Point{0, 0}, draw.Src)
+		img, err := decodeEntry(d, data)
+		if err != nil {
+			return nil, err
+		}
 
-		imgs[i] = img
+		// for CUR entries, the ICONDIRENTRY's Planes/BitCount fields are reused to store
+		// the hotspot X/Y coordinate instead.
+		cursors = append(cursors, Cursor{Image: img, HotspotX: int(d.Planes), HotspotY: int(d.BitCount)})
 	}
 
-	return imgs, nil
+	return cursors, nil
 }
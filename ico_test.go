@@ -0,0 +1,208 @@
+package ico
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEncodeRejectsOversizedImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 50))
+
+	opts := &EncodeOptions{
+		Format: func(int, image.Image) ImageFormat { return FormatBMP },
+	}
+
+	var buf bytes.Buffer
+	err := Encode(&buf, []image.Image{img}, opts)
+	if err == nil {
+		t.Fatalf("Encode succeeded for a 300x50 image forced to FormatBMP, want an error")
+	}
+}
+
+func TestEncodeAllows256(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+
+	opts := &EncodeOptions{
+		Format: func(int, image.Image) ImageFormat { return FormatBMP },
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, []image.Image{img}, opts); err != nil {
+		t.Fatalf("Encode failed for a 256x256 image: %v", err)
+	}
+}
+
+// solidNRGBA returns a w x h image filled with a single color, preserving alpha exactly.
+func solidNRGBA(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// solidPaletted returns a w x h *image.Paletted, all pixels index 0, whose single-color
+// palette is c.
+func solidPaletted(w, h int, c color.NRGBA) *image.Paletted {
+	return image.NewPaletted(image.Rect(0, 0, w, h), color.Palette{c})
+}
+
+// TestEncodeDecodeRoundTrip encodes and decodes an image at each supported BMP bit depth and
+// checks the decoded pixel matches what was encoded, in particular that a 32-bit image with a
+// semi-transparent pixel survives AutoMask encoding without its RGB channels being darkened by
+// the alpha channel.
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		bitCount int
+		autoMask bool
+		img      image.Image
+	}{
+		{"32bpp opaque", 32, false, solidNRGBA(2, 2, color.NRGBA{R: 30, G: 40, B: 100, A: 255})},
+		{"32bpp AutoMask semi-transparent", 32, true, solidNRGBA(2, 2, color.NRGBA{R: 30, G: 40, B: 100, A: 200})},
+		{"24bpp", 24, false, solidNRGBA(2, 2, color.NRGBA{R: 30, G: 40, B: 100, A: 255})},
+		{"8bpp paletted", 8, false, solidPaletted(2, 2, color.NRGBA{R: 30, G: 40, B: 100, A: 255})},
+		{"4bpp paletted", 4, false, solidPaletted(2, 2, color.NRGBA{R: 30, G: 40, B: 100, A: 255})},
+		{"1bpp paletted", 1, false, solidPaletted(2, 2, color.NRGBA{R: 30, G: 40, B: 100, A: 255})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &EncodeOptions{
+				Format:   func(int, image.Image) ImageFormat { return FormatBMP },
+				BitCount: func(int, image.Image) int { return tt.bitCount },
+				AutoMask: tt.autoMask,
+			}
+
+			var buf bytes.Buffer
+			if err := Encode(&buf, []image.Image{tt.img}, opts); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			imgs, err := Decode(&buf)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if len(imgs) != 1 {
+				t.Fatalf("len(imgs) = %d, want 1", len(imgs))
+			}
+
+			want := color.NRGBAModel.Convert(tt.img.At(0, 0)).(color.NRGBA)
+			got := color.NRGBAModel.Convert(imgs[0].At(0, 0)).(color.NRGBA)
+			if got != want {
+				t.Errorf("decoded pixel = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// TestDecodeFallsBackToANDMaskForZeroAlpha covers 32bpp icons that leave every XOR alpha
+// byte 0, a convention some older encoders use when they treat the 4th byte as unused
+// padding and rely solely on the AND mask for transparency. Decode must fall back to the AND
+// mask instead of returning an entirely transparent image, matching what Windows itself does.
+func TestDecodeFallsBackToANDMaskForZeroAlpha(t *testing.T) {
+	img := solidNRGBA(2, 2, color.NRGBA{R: 30, G: 40, B: 100, A: 0})
+
+	opts := &EncodeOptions{
+		Format:   func(int, image.Image) ImageFormat { return FormatBMP },
+		BitCount: func(int, image.Image) int { return 32 },
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, []image.Image{img}, opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	imgs, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if len(imgs) != 1 {
+		t.Fatalf("len(imgs) = %d, want 1", len(imgs))
+	}
+
+	want := color.NRGBA{R: 30, G: 40, B: 100, A: 255}
+	got := color.NRGBAModel.Convert(imgs[0].At(0, 0)).(color.NRGBA)
+	if got != want {
+		t.Errorf("decoded pixel = %+v, want %+v (AND mask fallback, fully opaque)", got, want)
+	}
+}
+
+func multiSizeICO(t *testing.T) []byte {
+	t.Helper()
+
+	imgs := []image.Image{
+		image.NewRGBA(image.Rect(0, 0, 16, 16)),
+		image.NewRGBA(image.Rect(0, 0, 32, 32)),
+	}
+	opts := &EncodeOptions{
+		Format: func(int, image.Image) ImageFormat { return FormatBMP },
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, imgs, opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeFirstSelectsLargest(t *testing.T) {
+	data := multiSizeICO(t)
+
+	img, err := DecodeFirst(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodeFirst: %v", err)
+	}
+
+	b := img.Bounds()
+	if b.Dx() != 32 || b.Dy() != 32 {
+		t.Errorf("DecodeFirst returned a %dx%d image, want the largest entry (32x32)", b.Dx(), b.Dy())
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	data := multiSizeICO(t)
+
+	cfg, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodeConfig: %v", err)
+	}
+
+	if cfg.Width != 32 || cfg.Height != 32 {
+		t.Errorf("DecodeConfig = %dx%d, want the largest entry (32x32)", cfg.Width, cfg.Height)
+	}
+}
+
+// TestRegisterFormat checks ico is wired into the image package's format registry: the
+// package's init() call to image.RegisterFormat lets image.Decode and image.DecodeConfig
+// dispatch an .ico file to DecodeFirst/DecodeConfig without the caller importing ico
+// directly for that purpose.
+func TestRegisterFormat(t *testing.T) {
+	data := multiSizeICO(t)
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("image.Decode: %v", err)
+	}
+	if format != "ico" {
+		t.Errorf("image.Decode format = %q, want %q", format, "ico")
+	}
+	if b := img.Bounds(); b.Dx() != 32 || b.Dy() != 32 {
+		t.Errorf("image.Decode returned a %dx%d image, want the largest entry (32x32)", b.Dx(), b.Dy())
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("image.DecodeConfig: %v", err)
+	}
+	if format != "ico" {
+		t.Errorf("image.DecodeConfig format = %q, want %q", format, "ico")
+	}
+	if cfg.Width != 32 || cfg.Height != 32 {
+		t.Errorf("image.DecodeConfig = %dx%d, want the largest entry (32x32)", cfg.Width, cfg.Height)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"image"
 	"image/color"
 	"io"
+	"io/ioutil"
+	"math/bits"
 )
 
 const (
@@ -13,6 +15,15 @@ const (
 	infoHeaderSize = 40
 )
 
+// Compression methods, as stored in InfoHeader.Compression.
+const (
+	biRGB            = 0
+	biRLE8           = 1
+	biRLE4           = 2
+	biBitfields      = 3
+	biAlphaBitfields = 6
+)
+
 type fileHeader struct {
 	Signature  uint16
 	FileSize   uint32
@@ -50,9 +61,45 @@ type infoHeaderWithoutSize struct {
 	ColorImportant uint32
 }
 
+// Color space types, as stored in infoHeaderV4.CSType.
+const (
+	lcsCalibratedRGB     = 0x00000000
+	lcsProfileLinked     = 0x4C494E4B // 'LINK'
+	lcsProfileEmbedded   = 0x4D424544 // 'MBED'
+	lcsWindowsColorSpace = 0x57696E20 // 'Win '
+	lcsSRGB              = 0x73524742 // 'sRGB'
+)
+
+// infoHeaderV4 is the BITMAPV4HEADER extension over BITMAPINFOHEADER: explicit channel
+// masks plus CIE calibration data, used when CSType is LCS_CALIBRATED_RGB.
+type infoHeaderV4 struct {
+	RedMask    uint32
+	GreenMask  uint32
+	BlueMask   uint32
+	AlphaMask  uint32
+	CSType     uint32
+	Endpoints  [9]int32 // CIEXYZTRIPLE: 3 FXPT2DOT30 fields per CIEXYZ endpoint
+	GammaRed   uint32
+	GammaGreen uint32
+	GammaBlue  uint32
+}
+
+// infoHeaderV5 is the BITMAPV5HEADER extension over BITMAPV4HEADER: ICC profile location
+// and rendering intent.
+type infoHeaderV5 struct {
+	Intent      uint32
+	ProfileData uint32
+	ProfileSize uint32
+	Reserved    uint32
+}
+
 type InfoHeader struct {
 	Size uint32
 	infoHeaderWithoutSize
+	// infoHeaderV4 and infoHeaderV5 are zero when Size is 40 (BITMAPINFOHEADER) or,
+	// for infoHeaderV5, when Size is 40 or 108.
+	infoHeaderV4
+	infoHeaderV5
 }
 
 func ReadInfoHeader(r io.Reader) (InfoHeader, error) {
@@ -79,9 +126,36 @@ func ReadInfoHeader(r io.Reader) (InfoHeader, error) {
 		return InfoHeader{}, fmt.Errorf("bmp: height should be non-zero (got: %d)", h.Height)
 	}
 
+	if size >= 108 {
+		if err := binary.Read(r, binary.LittleEndian, &h.infoHeaderV4); err != nil {
+			return InfoHeader{}, err
+		}
+	}
+
+	if size >= 124 {
+		if err := binary.Read(r, binary.LittleEndian, &h.infoHeaderV5); err != nil {
+			return InfoHeader{}, err
+		}
+	}
+
 	return h, nil
 }
 
+// ReadColorTable reads n BGR color table entries, as found after the DIB header of a
+// 1/4/8-bpp bitmap, and returns them as a color.Palette.
+func ReadColorTable(r io.Reader, n int) (color.Palette, error) {
+	clrs := make([]colorBGR, n)
+	if err := binary.Read(r, binary.LittleEndian, &clrs); err != nil {
+		return nil, err
+	}
+
+	pal := make(color.Palette, n)
+	for i := range pal {
+		pal[i] = clrs[i].RGBA()
+	}
+	return pal, nil
+}
+
 // colorBGR is BGR order
 type colorBGR struct {
 	B        uint8
@@ -94,10 +168,36 @@ func (c colorBGR) RGBA() color.RGBA {
 	return color.RGBA{c.R, c.G, c.B, 0xff}
 }
 
+// Profile carries the color-space metadata found in a BITMAPV4HEADER/BITMAPV5HEADER: the
+// declared color space, rendering intent and, when CSType is LCS_PROFILE_EMBEDDED, the raw
+// bytes of the embedded ICC profile.
+type Profile struct {
+	CSType                          uint32
+	Intent                          uint32
+	GammaRed, GammaGreen, GammaBlue uint32
+	ICC                             []byte
+}
+
+// ImageWithProfile wraps an image.Image decoded from a BITMAPV4HEADER/BITMAPV5HEADER bitmap
+// with its color-space Profile. Decode returns this instead of the plain image.Image when the
+// source DIB header carries one.
+type ImageWithProfile struct {
+	image.Image
+	Profile Profile
+}
+
 type decoder struct {
-	bpp        int
-	isOpposite bool
-	config     image.Config
+	bpp         int
+	isOpposite  bool
+	config      image.Config
+	compression uint32
+	// masks holds the R, G, B and A bitfield masks used to decode 16/32-bpp BITFIELDS
+	// images; masks[3] is zero when the bitmap carries no alpha mask.
+	masks [4]uint32
+	// profile is non-nil when the DIB header is a BITMAPV4HEADER/BITMAPV5HEADER.
+	profile *Profile
+	// ih is the full parsed DIB header, kept around for readProfile.
+	ih InfoHeader
 }
 
 func newDecoder(r io.Reader) (*decoder, error) {
@@ -123,8 +223,20 @@ func newDecoder(r io.Reader) (*decoder, error) {
 		isOpposite = true
 	}
 
-	if ih.Compression != 0 {
-		return nil, fmt.Errorf("bmp: supported compression method is only 0 (got: %d)", ih.Compression)
+	switch ih.Compression {
+	case biRGB, biRLE8, biRLE4, biBitfields, biAlphaBitfields:
+	default:
+		return nil, fmt.Errorf("bmp: unsupported compression method (got: %d)", ih.Compression)
+	}
+
+	if ih.Compression == biRLE8 && ih.BitCount != 8 {
+		return nil, fmt.Errorf("bmp: RLE8 compression requires an 8-bit image (got: %d bpp)", ih.BitCount)
+	}
+	if ih.Compression == biRLE4 && ih.BitCount != 4 {
+		return nil, fmt.Errorf("bmp: RLE4 compression requires a 4-bit image (got: %d bpp)", ih.BitCount)
+	}
+	if (ih.Compression == biBitfields || ih.Compression == biAlphaBitfields) && ih.BitCount != 16 && ih.BitCount != 32 {
+		return nil, fmt.Errorf("bmp: BITFIELDS compression requires a 16- or 32-bit image (got: %d bpp)", ih.BitCount)
 	}
 
 	if ih.ColorUsed == 0 {
@@ -132,35 +244,112 @@ func newDecoder(r io.Reader) (*decoder, error) {
 	}
 
 	var model color.Model
+	var masks [4]uint32
 
 	switch ih.BitCount {
 	case 1, 4, 8:
-		clrs := make([]colorBGR, ih.ColorUsed)
-		if err := binary.Read(r, binary.LittleEndian, &clrs); err != nil {
+		colorTable, err := ReadColorTable(r, int(ih.ColorUsed))
+		if err != nil {
 			return nil, err
 		}
-		colorTable := make(color.Palette, ih.ColorUsed)
-		for i := range colorTable {
-			colorTable[i] = clrs[i].RGBA()
-		}
 		model = colorTable
-	case 16, 24, 32:
+	case 16, 32:
+		switch {
+		case ih.Size >= 108 && (ih.Compression == biBitfields || ih.Compression == biAlphaBitfields):
+			// BITMAPV4HEADER/BITMAPV5HEADER already carries the RGB masks inline; an
+			// alpha mask of zero below just means the bitmap has no alpha channel.
+			masks = [4]uint32{ih.RedMask, ih.GreenMask, ih.BlueMask, ih.AlphaMask}
+		case ih.Compression == biBitfields:
+			if err := binary.Read(r, binary.LittleEndian, masks[:3]); err != nil {
+				return nil, err
+			}
+		case ih.Compression == biAlphaBitfields:
+			if err := binary.Read(r, binary.LittleEndian, masks[:]); err != nil {
+				return nil, err
+			}
+		}
+		model = color.RGBAModel
+	case 24:
 		model = color.RGBAModel
 	default:
 		return nil, fmt.Errorf("bmp: unsupported bpp (got: %d)", ih.BitCount)
 	}
 
+	var profile *Profile
+	if ih.Size >= 108 {
+		profile = &Profile{
+			CSType:     ih.CSType,
+			Intent:     ih.Intent,
+			GammaRed:   ih.GammaRed,
+			GammaGreen: ih.GammaGreen,
+			GammaBlue:  ih.GammaBlue,
+		}
+	}
+
 	c := image.Config{ColorModel: model, Width: int(ih.Width), Height: int(ih.Height)}
 
 	d := &decoder{
-		bpp:        int(ih.BitCount),
-		isOpposite: isOpposite,
-		config:     c,
+		bpp:         int(ih.BitCount),
+		isOpposite:  isOpposite,
+		config:      c,
+		compression: ih.Compression,
+		masks:       masks,
+		profile:     profile,
+		ih:          ih,
 	}
 
 	return d, nil
 }
 
+// readProfile reads d's embedded ICC profile, if any, into d.profile.ICC. ProfileData is an
+// offset from the start of the DIB header and ordinarily points past the pixel array, so this
+// must run after decode() has consumed the pixel data; n is the number of bytes read from r
+// since the DIB header ended. r isn't generally seekable, so this is a best-effort read: it
+// gives up on a negative offset rather than guessing.
+func (d *decoder) readProfile(r io.Reader, n int64) error {
+	if d.profile == nil || d.ih.CSType != lcsProfileEmbedded || d.ih.ProfileSize == 0 {
+		return nil
+	}
+
+	skip := int64(d.ih.ProfileData) - int64(d.ih.Size) - n
+	if skip < 0 {
+		return nil
+	}
+	if skip > 0 {
+		if _, err := io.CopyN(ioutil.Discard, r, skip); err != nil {
+			return err
+		}
+	}
+
+	icc := make([]byte, d.ih.ProfileSize)
+	if _, err := io.ReadFull(r, icc); err != nil {
+		return err
+	}
+	d.profile.ICC = icc
+
+	return nil
+}
+
+// maskShiftWidth returns the bit offset and width of a BITFIELDS color channel mask.
+func maskShiftWidth(mask uint32) (shift, width uint) {
+	if mask == 0 {
+		return 0, 0
+	}
+	return uint(bits.TrailingZeros32(mask)), uint(bits.OnesCount32(mask))
+}
+
+// scaleTo8 widens a width-bit channel value to 8 bits.
+func scaleTo8(v uint32, width uint) uint8 {
+	if width == 0 {
+		return 0
+	}
+	if width >= 8 {
+		return uint8(v >> (width - 8))
+	}
+	max := uint32(1)<<width - 1
+	return uint8(v * 255 / max)
+}
+
 func (d *decoder) decode1(r io.Reader) (image.Image, error) {
 	w, h := d.config.Width, d.config.Height
 	paletted := image.NewPaletted(image.Rect(0, 0, w, h), d.config.ColorModel.(color.Palette))
@@ -254,6 +443,249 @@ func (d *decoder) decode8(r io.Reader) (image.Image, error) {
 	return paletted, nil
 }
 
+// decode16 decodes an uncompressed 16-bpp bitmap using the default 5-5-5 color masks.
+func (d *decoder) decode16(r io.Reader) (image.Image, error) {
+	w, h := d.config.Width, d.config.Height
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	y0, y1, dy := h-1, -1, -1
+	if d.isOpposite {
+		y0, y1, dy = 0, h, 1
+	}
+
+	// row data must be an integer multiple of 4 bytes
+	row := make([]byte, (w*16+31)/32*4)
+	for y := y0; y != y1; y += dy {
+		if _, err := io.ReadFull(r, row); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		p := rgba.Pix[y*rgba.Stride : (y+1)*rgba.Stride]
+		for x := 0; x < w; x++ {
+			v := uint32(binary.LittleEndian.Uint16(row[x*2:]))
+			p[x*4+0] = scaleTo8((v>>10)&0x1f, 5)
+			p[x*4+1] = scaleTo8((v>>5)&0x1f, 5)
+			p[x*4+2] = scaleTo8(v&0x1f, 5)
+			p[x*4+3] = 0xff
+		}
+	}
+
+	return rgba, nil
+}
+
+// decodeBitfields decodes a 16/32-bpp BITFIELDS (or ALPHABITFIELDS) bitmap using d.masks.
+func (d *decoder) decodeBitfields(r io.Reader) (image.Image, error) {
+	w, h := d.config.Width, d.config.Height
+	bytesPerPixel := d.bpp / 8
+	stride := (w*d.bpp + 31) / 32 * 4
+
+	rShift, rWidth := maskShiftWidth(d.masks[0])
+	gShift, gWidth := maskShiftWidth(d.masks[1])
+	bShift, bWidth := maskShiftWidth(d.masks[2])
+	aShift, aWidth := maskShiftWidth(d.masks[3])
+	hasAlpha := d.masks[3] != 0
+
+	y0, y1, dy := h-1, -1, -1
+	if d.isOpposite {
+		y0, y1, dy = 0, h, 1
+	}
+
+	readPixel := func(row []byte, x int) uint32 {
+		if bytesPerPixel == 2 {
+			return uint32(binary.LittleEndian.Uint16(row[x*2:]))
+		}
+		return binary.LittleEndian.Uint32(row[x*4:])
+	}
+
+	row := make([]byte, stride)
+
+	if hasAlpha {
+		nrgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for y := y0; y != y1; y += dy {
+			if _, err := io.ReadFull(r, row); err != nil {
+				if err == io.EOF {
+					return nil, io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+
+			p := nrgba.Pix[y*nrgba.Stride : (y+1)*nrgba.Stride]
+			for x := 0; x < w; x++ {
+				v := readPixel(row, x)
+				p[x*4+0] = scaleTo8((v&d.masks[0])>>rShift, rWidth)
+				p[x*4+1] = scaleTo8((v&d.masks[1])>>gShift, gWidth)
+				p[x*4+2] = scaleTo8((v&d.masks[2])>>bShift, bWidth)
+				p[x*4+3] = scaleTo8((v&d.masks[3])>>aShift, aWidth)
+			}
+		}
+		return nrgba, nil
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := y0; y != y1; y += dy {
+		if _, err := io.ReadFull(r, row); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		p := rgba.Pix[y*rgba.Stride : (y+1)*rgba.Stride]
+		for x := 0; x < w; x++ {
+			v := readPixel(row, x)
+			p[x*4+0] = scaleTo8((v&d.masks[0])>>rShift, rWidth)
+			p[x*4+1] = scaleTo8((v&d.masks[1])>>gShift, gWidth)
+			p[x*4+2] = scaleTo8((v&d.masks[2])>>bShift, bWidth)
+			p[x*4+3] = 0xff
+		}
+	}
+	return rgba, nil
+}
+
+// decodeRLE8 decodes an 8-bpp RLE-compressed bitmap. Deltas and end-of-line markers can
+// leave gaps in the bitmap; paletted.Pix is zero-initialized, so skipped pixels keep
+// palette index 0 as the background.
+func (d *decoder) decodeRLE8(r io.Reader) (image.Image, error) {
+	w, h := d.config.Width, d.config.Height
+	paletted := image.NewPaletted(image.Rect(0, 0, w, h), d.config.ColorModel.(color.Palette))
+
+	y, dy := h-1, -1
+	if d.isOpposite {
+		y, dy = 0, 1
+	}
+	x := 0
+
+	set := func(idx byte) {
+		if x >= 0 && x < w && y >= 0 && y < h {
+			paletted.Pix[y*paletted.Stride+x] = idx
+		}
+		x++
+	}
+
+	pair := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(r, pair); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		n, b := pair[0], pair[1]
+		if n > 0 {
+			for i := byte(0); i < n; i++ {
+				set(b)
+			}
+			continue
+		}
+
+		switch b {
+		case 0: // end of line
+			x, y = 0, y+dy
+		case 1: // end of bitmap
+			return paletted, nil
+		case 2: // delta
+			delta := make([]byte, 2)
+			if _, err := io.ReadFull(r, delta); err != nil {
+				return nil, err
+			}
+			x += int(delta[0])
+			y += dy * int(delta[1])
+		default: // absolute mode: b raw indices, padded to a 16-bit boundary
+			raw := make([]byte, b)
+			if _, err := io.ReadFull(r, raw); err != nil {
+				return nil, err
+			}
+			if b%2 == 1 {
+				if _, err := io.ReadFull(r, pair[:1]); err != nil {
+					return nil, err
+				}
+			}
+			for _, idx := range raw {
+				set(idx)
+			}
+		}
+	}
+}
+
+// decodeRLE4 decodes a 4-bpp RLE-compressed bitmap, analogous to decodeRLE8 but with each
+// palette index packed into a nibble.
+func (d *decoder) decodeRLE4(r io.Reader) (image.Image, error) {
+	w, h := d.config.Width, d.config.Height
+	paletted := image.NewPaletted(image.Rect(0, 0, w, h), d.config.ColorModel.(color.Palette))
+
+	y, dy := h-1, -1
+	if d.isOpposite {
+		y, dy = 0, 1
+	}
+	x := 0
+
+	set := func(idx byte) {
+		if x >= 0 && x < w && y >= 0 && y < h {
+			paletted.Pix[y*paletted.Stride+x] = idx
+		}
+		x++
+	}
+
+	pair := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(r, pair); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		n, b := pair[0], pair[1]
+		hi, lo := b>>4, b&0xf
+		if n > 0 {
+			for i := byte(0); i < n; i++ {
+				if i%2 == 0 {
+					set(hi)
+				} else {
+					set(lo)
+				}
+			}
+			continue
+		}
+
+		switch b {
+		case 0: // end of line
+			x, y = 0, y+dy
+		case 1: // end of bitmap
+			return paletted, nil
+		case 2: // delta
+			delta := make([]byte, 2)
+			if _, err := io.ReadFull(r, delta); err != nil {
+				return nil, err
+			}
+			x += int(delta[0])
+			y += dy * int(delta[1])
+		default: // absolute mode: b raw indices, two per byte, padded to a 16-bit boundary
+			raw := make([]byte, (int(b)+1)/2)
+			if _, err := io.ReadFull(r, raw); err != nil {
+				return nil, err
+			}
+			if len(raw)%2 == 1 {
+				if _, err := io.ReadFull(r, pair[:1]); err != nil {
+					return nil, err
+				}
+			}
+			for i := 0; i < int(b); i++ {
+				byt := raw[i/2]
+				if i%2 == 0 {
+					set(byt >> 4)
+				} else {
+					set(byt & 0xf)
+				}
+			}
+		}
+	}
+}
+
 func (d *decoder) decode24(r io.Reader) (image.Image, error) {
 	w, h := d.config.Width, d.config.Height
 	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
@@ -314,6 +746,15 @@ func (d *decoder) decode32(r io.Reader) (image.Image, error) {
 }
 
 func (d *decoder) decode(r io.Reader) (image.Image, error) {
+	switch d.compression {
+	case biRLE8:
+		return d.decodeRLE8(r)
+	case biRLE4:
+		return d.decodeRLE4(r)
+	case biBitfields, biAlphaBitfields:
+		return d.decodeBitfields(r)
+	}
+
 	switch d.bpp {
 	case 1:
 		return d.decode1(r)
@@ -321,6 +762,8 @@ func (d *decoder) decode(r io.Reader) (image.Image, error) {
 		return d.decode4(r)
 	case 8:
 		return d.decode8(r)
+	case 16:
+		return d.decode16(r)
 	case 24:
 		return d.decode24(r)
 	case 32:
@@ -330,21 +773,43 @@ func (d *decoder) decode(r io.Reader) (image.Image, error) {
 	return nil, fmt.Errorf("bmp: the bpp decode fucntion isn't implemented (got: %d)", d.bpp)
 }
 
-// Decode reads a BMP image form io.Reader and returns an image.Image
+// Decode reads a BMP image form io.Reader and returns an image.Image. When the source DIB
+// header is a BITMAPV4HEADER/BITMAPV5HEADER, the returned image is an ImageWithProfile
+// carrying its color-space Profile.
 func Decode(r io.Reader) (image.Image, error) {
 	d, err := newDecoder(r)
 	if err != nil {
 		return nil, err
 	}
 
-	img, err := d.decode(r)
+	cr := &countingReader{r: r}
+	img, err := d.decode(cr)
 	if err != nil {
 		return nil, err
 	}
 
+	if d.profile != nil {
+		if err := d.readProfile(cr, cr.n); err != nil {
+			return nil, err
+		}
+		return ImageWithProfile{Image: img, Profile: *d.profile}, nil
+	}
+
 	return img, nil
 }
 
+// countingReader wraps an io.Reader and tracks the number of bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
 // DecodeConfig reads a BMP image from io.Reader and returns an image.Config
 func DecodeConfig(r io.Reader) (image.Config, error) {
 	d, err := newDecoder(r)
@@ -0,0 +1,251 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+// buildBMP assembles a standalone .bmp file (BITMAPFILEHEADER + the given DIB header +
+// color table + pixel data) for feeding to Decode in tests.
+func buildBMP(t *testing.T, ih InfoHeader, colorTable []colorBGR, pixels []byte) []byte {
+	t.Helper()
+
+	var dib bytes.Buffer
+	if err := binary.Write(&dib, binary.LittleEndian, ih.Size); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Write(&dib, binary.LittleEndian, ih.infoHeaderWithoutSize); err != nil {
+		t.Fatal(err)
+	}
+	if ih.Size >= 108 {
+		if err := binary.Write(&dib, binary.LittleEndian, ih.infoHeaderV4); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ih.Size >= 124 {
+		if err := binary.Write(&dib, binary.LittleEndian, ih.infoHeaderV5); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	offsetBits := fileHeaderSize + dib.Len() + len(colorTable)*4
+
+	var buf bytes.Buffer
+	buf.WriteString("BM")
+	binary.Write(&buf, binary.LittleEndian, uint32(offsetBits+len(pixels)))
+	binary.Write(&buf, binary.LittleEndian, uint16(0))
+	binary.Write(&buf, binary.LittleEndian, uint16(0))
+	binary.Write(&buf, binary.LittleEndian, uint32(offsetBits))
+	buf.Write(dib.Bytes())
+	binary.Write(&buf, binary.LittleEndian, colorTable)
+	buf.Write(pixels)
+
+	return buf.Bytes()
+}
+
+func TestDecodeRLE8(t *testing.T) {
+	ih := InfoHeader{
+		Size: infoHeaderSize,
+		infoHeaderWithoutSize: infoHeaderWithoutSize{
+			Width: 4, Height: 2, Planes: 1, BitCount: 8, Compression: biRLE8, ColorUsed: 2,
+		},
+	}
+	pal := []colorBGR{{B: 0, G: 0, R: 0}, {B: 0xff, G: 0xff, R: 0xff}}
+
+	// bottom row: run of 4 pixels at palette index 1, then end-of-line; top row: an
+	// absolute run of 4 raw indices, then end-of-bitmap.
+	pixels := []byte{4, 1, 0, 0, 0, 4, 0, 0, 1, 1, 0, 1}
+
+	data := buildBMP(t, ih, pal, pixels)
+
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	p, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("Decode returned %T, want *image.Paletted", img)
+	}
+
+	want := [][]byte{{0, 0, 1, 1}, {1, 1, 1, 1}}
+	for y, row := range want {
+		for x, idx := range row {
+			if got := p.Pix[y*p.Stride+x]; got != idx {
+				t.Errorf("pixel (%d,%d) = %d, want %d", x, y, got, idx)
+			}
+		}
+	}
+}
+
+func TestDecodeRLE4(t *testing.T) {
+	ih := InfoHeader{
+		Size: infoHeaderSize,
+		infoHeaderWithoutSize: infoHeaderWithoutSize{
+			Width: 4, Height: 1, Planes: 1, BitCount: 4, Compression: biRLE4, ColorUsed: 2,
+		},
+	}
+	pal := []colorBGR{{B: 0, G: 0, R: 0}, {B: 0xff, G: 0xff, R: 0xff}}
+
+	// absolute run of 4 indices (0,1,0,1) packed two per byte, then end-of-bitmap.
+	pixels := []byte{0, 4, 0x01, 0x01, 0, 1}
+
+	data := buildBMP(t, ih, pal, pixels)
+
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	p, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("Decode returned %T, want *image.Paletted", img)
+	}
+
+	want := []byte{0, 1, 0, 1}
+	for x, idx := range want {
+		if got := p.Pix[x]; got != idx {
+			t.Errorf("pixel (%d,0) = %d, want %d", x, got, idx)
+		}
+	}
+}
+
+func TestDecodeBitfields565(t *testing.T) {
+	ih := InfoHeader{
+		Size: infoHeaderSize,
+		infoHeaderWithoutSize: infoHeaderWithoutSize{
+			Width: 1, Height: 1, Planes: 1, BitCount: 16, Compression: biBitfields,
+		},
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(0xF800)) // R
+	binary.Write(&buf, binary.LittleEndian, uint32(0x07E0)) // G
+	binary.Write(&buf, binary.LittleEndian, uint32(0x001F)) // B
+	binary.Write(&buf, binary.LittleEndian, uint16(0xF800))
+	binary.Write(&buf, binary.LittleEndian, uint16(0)) // row padding to 4 bytes
+
+	// masks precede the pixel data in buildBMP's pixel payload for the <108-byte header case.
+	pixels := buf.Bytes()
+
+	data := buildBMP(t, ih, nil, pixels)
+
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	r, g, b, a := img.At(0, 0).RGBA()
+	if r>>8 != 0xff || g>>8 != 0 || b>>8 != 0 || a>>8 != 0xff {
+		t.Errorf("At(0,0) = %d,%d,%d,%d, want pure opaque red", r>>8, g>>8, b>>8, a>>8)
+	}
+}
+
+func TestNewDecoderRejectsBitfieldsAtUnsupportedBitCount(t *testing.T) {
+	ih := InfoHeader{
+		Size: infoHeaderSize,
+		infoHeaderWithoutSize: infoHeaderWithoutSize{
+			Width: 1, Height: 1, Planes: 1, BitCount: 8, Compression: biBitfields, ColorUsed: 1,
+		},
+	}
+	pal := []colorBGR{{}}
+
+	data := buildBMP(t, ih, pal, []byte{0, 0, 0, 0})
+
+	if _, err := Decode(bytes.NewReader(data)); err == nil {
+		t.Fatalf("Decode succeeded for BITFIELDS at 8bpp, want an error")
+	}
+}
+
+func TestDecode16DefaultMask(t *testing.T) {
+	ih := InfoHeader{
+		Size: infoHeaderSize,
+		infoHeaderWithoutSize: infoHeaderWithoutSize{
+			Width: 1, Height: 1, Planes: 1, BitCount: 16, Compression: biRGB,
+		},
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint16(0x7C00)) // pure red in 5-5-5
+	binary.Write(&buf, binary.LittleEndian, uint16(0))      // row padding
+
+	data := buildBMP(t, ih, nil, buf.Bytes())
+
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	r, g, b, a := img.At(0, 0).RGBA()
+	if r>>8 != 0xff || g>>8 != 0 || b>>8 != 0 || a>>8 != 0xff {
+		t.Errorf("At(0,0) = %d,%d,%d,%d, want pure opaque red", r>>8, g>>8, b>>8, a>>8)
+	}
+}
+
+func TestReadInfoHeaderV4Masks(t *testing.T) {
+	ih := InfoHeader{
+		Size: 108,
+		infoHeaderWithoutSize: infoHeaderWithoutSize{
+			Width: 1, Height: 1, Planes: 1, BitCount: 32, Compression: biBitfields,
+		},
+		infoHeaderV4: infoHeaderV4{
+			RedMask: 0xff0000, GreenMask: 0xff00, BlueMask: 0xff, AlphaMask: 0xff000000,
+			CSType: lcsSRGB, GammaRed: 1, GammaGreen: 2, GammaBlue: 3,
+		},
+	}
+
+	var dib bytes.Buffer
+	binary.Write(&dib, binary.LittleEndian, ih.Size)
+	binary.Write(&dib, binary.LittleEndian, ih.infoHeaderWithoutSize)
+	binary.Write(&dib, binary.LittleEndian, ih.infoHeaderV4)
+
+	got, err := ReadInfoHeader(bytes.NewReader(dib.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadInfoHeader: %v", err)
+	}
+
+	if got.RedMask != ih.RedMask || got.GreenMask != ih.GreenMask || got.BlueMask != ih.BlueMask || got.AlphaMask != ih.AlphaMask {
+		t.Errorf("masks = %#x,%#x,%#x,%#x, want %#x,%#x,%#x,%#x",
+			got.RedMask, got.GreenMask, got.BlueMask, got.AlphaMask,
+			ih.RedMask, ih.GreenMask, ih.BlueMask, ih.AlphaMask)
+	}
+	if got.CSType != lcsSRGB {
+		t.Errorf("CSType = %#x, want %#x", got.CSType, lcsSRGB)
+	}
+}
+
+func TestDecodeEmbeddedICCProfile(t *testing.T) {
+	icc := []byte("TEST")
+
+	ih := InfoHeader{
+		Size: 124,
+		infoHeaderWithoutSize: infoHeaderWithoutSize{
+			Width: 2, Height: 1, Planes: 1, BitCount: 24, Compression: biRGB,
+		},
+		infoHeaderV4: infoHeaderV4{
+			CSType: lcsProfileEmbedded,
+		},
+		infoHeaderV5: infoHeaderV5{
+			ProfileData: 124 + 8, // past the DIB header and the one pixel row
+			ProfileSize: uint32(len(icc)),
+		},
+	}
+
+	pixels := make([]byte, 8) // one 2px BGR row, padded to 4 bytes: 6 data + 2 pad
+	data := buildBMP(t, ih, nil, append(pixels, icc...))
+
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	wp, ok := img.(ImageWithProfile)
+	if !ok {
+		t.Fatalf("Decode returned %T, want ImageWithProfile", img)
+	}
+	if !bytes.Equal(wp.Profile.ICC, icc) {
+		t.Errorf("Profile.ICC = %q, want %q", wp.Profile.ICC, icc)
+	}
+}
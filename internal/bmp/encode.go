@@ -0,0 +1,186 @@
+package bmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// EncodeOptions controls how Encode serializes an image.
+type EncodeOptions struct {
+	// BitCount is the DIB bit depth to write: 32, 24, 8, 4 or 1. 8/4/1-bit encoding
+	// requires img to be an *image.Paletted whose palette fits the bit depth.
+	BitCount int
+
+	// AutoMask derives the AND mask from img's alpha channel (pixels with alpha below
+	// 0x80 are masked out) instead of writing a fully opaque mask.
+	AutoMask bool
+}
+
+// Encode writes img as the ICO/CUR-specific DIB payload used by newBMPReader: a
+// BITMAPINFOHEADER whose Height is twice the image height, an optional color table, the
+// XOR mask and, below it, the 1-bpp AND mask. Unlike a standalone .bmp file, no
+// BITMAPFILEHEADER is written.
+func Encode(w io.Writer, img image.Image, opts EncodeOptions) error {
+	bitCount := opts.BitCount
+	if bitCount == 0 {
+		bitCount = 32
+	}
+
+	var palette color.Palette
+	if bitCount <= 8 {
+		p, ok := img.(*image.Paletted)
+		if !ok {
+			return fmt.Errorf("bmp: %d-bit encoding requires an *image.Paletted image", bitCount)
+		}
+		if len(p.Palette) > 1<<uint(bitCount) {
+			return fmt.Errorf("bmp: palette has more than %d colors for %d-bit encoding", 1<<uint(bitCount), bitCount)
+		}
+		palette = p.Palette
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	xorStride := (width*bitCount + 31) / 32 * 4
+	andStride := (width + 31) / 32 * 4
+	xorSize := xorStride * height
+	andSize := andStride * height
+
+	ih := InfoHeader{
+		Size: infoHeaderSize,
+		infoHeaderWithoutSize: infoHeaderWithoutSize{
+			Width:       int32(width),
+			Height:      int32(height * 2),
+			Planes:      1,
+			BitCount:    uint16(bitCount),
+			Compression: 0,
+			SizeImage:   uint32(xorSize + andSize),
+			ColorUsed:   uint32(len(palette)),
+		},
+	}
+
+	if err := writeInfoHeader(w, ih); err != nil {
+		return err
+	}
+
+	for _, c := range palette {
+		bgr := colorToBGR(c)
+		if _, err := w.Write([]byte{bgr.B, bgr.G, bgr.R, 0}); err != nil {
+			return err
+		}
+	}
+
+	if err := encodeXOR(w, img, bitCount, xorStride); err != nil {
+		return err
+	}
+
+	return encodeAND(w, img, opts.AutoMask, andStride)
+}
+
+func writeInfoHeader(w io.Writer, ih InfoHeader) error {
+	if err := binary.Write(w, binary.LittleEndian, ih.Size); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, ih.infoHeaderWithoutSize)
+}
+
+func colorToBGR(c color.Color) colorBGR {
+	rgba := color.RGBAModel.Convert(c).(color.RGBA)
+	return colorBGR{B: rgba.B, G: rgba.G, R: rgba.R}
+}
+
+// encodeXOR writes img's color data bottom-up, one row of stride bytes at a time.
+func encodeXOR(w io.Writer, img image.Image, bitCount, stride int) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	row := make([]byte, stride)
+	for y := height - 1; y >= 0; y-- {
+		for i := range row {
+			row[i] = 0
+		}
+
+		py := bounds.Min.Y + y
+		switch bitCount {
+		case 32:
+			for x := 0; x < width; x++ {
+				c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, py)).(color.NRGBA)
+				row[x*4+0] = c.B
+				row[x*4+1] = c.G
+				row[x*4+2] = c.R
+				row[x*4+3] = c.A
+			}
+		case 24:
+			for x := 0; x < width; x++ {
+				c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, py)).(color.NRGBA)
+				row[x*3+0] = c.B
+				row[x*3+1] = c.G
+				row[x*3+2] = c.R
+			}
+		case 8:
+			p := img.(*image.Paletted)
+			for x := 0; x < width; x++ {
+				row[x] = p.ColorIndexAt(bounds.Min.X+x, py)
+			}
+		case 4:
+			p := img.(*image.Paletted)
+			for x := 0; x < width; x++ {
+				idx := p.ColorIndexAt(bounds.Min.X+x, py)
+				if x%2 == 0 {
+					row[x/2] |= idx << 4
+				} else {
+					row[x/2] |= idx & 0xf
+				}
+			}
+		case 1:
+			p := img.(*image.Paletted)
+			for x := 0; x < width; x++ {
+				idx := p.ColorIndexAt(bounds.Min.X+x, py)
+				if idx != 0 {
+					row[x/8] |= 0x80 >> uint(x%8)
+				}
+			}
+		default:
+			return fmt.Errorf("bmp: unsupported bpp (got: %d)", bitCount)
+		}
+
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeAND writes img's 1-bpp AND mask bottom-up. A set bit hides the corresponding XOR
+// pixel, matching the AND mask layout read back by newBMPReader.
+func encodeAND(w io.Writer, img image.Image, auto bool, stride int) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	row := make([]byte, stride)
+	for y := height - 1; y >= 0; y-- {
+		for i := range row {
+			row[i] = 0
+		}
+
+		if auto {
+			py := bounds.Min.Y + y
+			for x := 0; x < width; x++ {
+				_, _, _, a := img.At(bounds.Min.X+x, py).RGBA()
+				if a>>8 < 0x80 {
+					row[x/8] |= 0x80 >> uint(x%8)
+				}
+			}
+		}
+
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}